@@ -0,0 +1,93 @@
+// Package concurrent provides a fan-out io.Writer for tee-ing a single
+// stream of writes to several underlying writers in parallel.
+package concurrent
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Writer is an io.Writer that fans every Write call out to N underlying
+// writers, each draining its own bounded channel on a dedicated goroutine.
+// Call Wait once all writes have been queued to drain the goroutines and
+// collect the first error encountered by any writer.
+type Writer struct {
+	writers []io.Writer
+	chans   []chan []byte
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	errs      []error
+	durations []time.Duration
+}
+
+// New starts one goroutine per writer, each reading from a channel of the
+// given depth. A depth of 0 means each writer must keep up with every
+// Write call before the next one is queued.
+func New(depth int, writers ...io.Writer) *Writer {
+	w := &Writer{
+		writers:   writers,
+		chans:     make([]chan []byte, len(writers)),
+		errs:      make([]error, len(writers)),
+		durations: make([]time.Duration, len(writers)),
+	}
+
+	for i, wr := range writers {
+		w.chans[i] = make(chan []byte, depth)
+		w.wg.Add(1)
+		go w.drain(i, wr, w.chans[i])
+	}
+
+	return w
+}
+
+func (w *Writer) drain(i int, wr io.Writer, ch <-chan []byte) {
+	defer w.wg.Done()
+
+	for b := range ch {
+		start := time.Now()
+		_, err := wr.Write(b)
+		elapsed := time.Since(start)
+
+		w.mu.Lock()
+		w.durations[i] += elapsed
+		if err != nil && w.errs[i] == nil {
+			w.errs[i] = err
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Write copies p and queues it on every underlying writer's channel.
+func (w *Writer) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	for _, ch := range w.chans {
+		ch <- b
+	}
+	return len(p), nil
+}
+
+// Wait closes every channel, waits for all writer goroutines to drain, and
+// returns the first error encountered by any of them.
+func (w *Writer) Wait() error {
+	for _, ch := range w.chans {
+		close(ch)
+	}
+	w.wg.Wait()
+
+	for _, err := range w.errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Durations returns, per underlying writer and in the order passed to New,
+// the total time spent inside that writer's Write calls.
+func (w *Writer) Durations() []time.Duration {
+	return w.durations
+}
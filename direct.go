@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// alignedBuffer returns a size-byte slice whose backing array starts at an
+// address that is a multiple of align, by over-allocating and trimming the
+// unaligned head.
+func alignedBuffer(size, align int) []byte {
+	if align <= 1 {
+		return make([]byte, size)
+	}
+
+	buf := make([]byte, size+align)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % uintptr(align)); rem != 0 {
+		offset = align - rem
+	}
+	return buf[offset : offset+size : offset+size]
+}
+
+// shardBufKey identifies one destination (a shard's part file, or its
+// replica) written by one worker, so each gets its own reused buffer.
+type shardBufKey struct {
+	worker, shard int
+	replica       bool
+}
+
+// shardBufferPool hands out one reusable aligned buffer per shardBufKey.
+// directWriter uses it to align the bytes it actually hands to the
+// O_DIRECT file, since neither the shard slice produced by enc.Split nor
+// whatever concurrent.Writer copies it into is guaranteed to be
+// address-aligned even when its length already is.
+type shardBufferPool struct {
+	mu   sync.Mutex
+	bufs map[shardBufKey][]byte
+}
+
+var directBufPool = &shardBufferPool{bufs: map[shardBufKey][]byte{}}
+
+// get returns the buffer for key, aligned to align, sliced to size and
+// (re)allocated if it is smaller than size.
+func (p *shardBufferPool) get(key shardBufKey, size, align int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	buf := p.bufs[key]
+	if cap(buf) < size {
+		buf = alignedBuffer(size, align)
+		p.bufs[key] = buf
+	}
+	return buf[:size]
+}
+
+// createShardFile opens path for writing, using O_DIRECT when -direct is
+// set. Falls back to a regular buffered open if the filesystem rejects it.
+func createShardFile(path string) (f *os.File, isDirect bool, err error) {
+	if !*direct {
+		f, err = os.Create(path)
+		return f, false, err
+	}
+
+	f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|unix.O_DIRECT, os.ModePerm)
+	if err != nil {
+		f, err = os.Create(path)
+		return f, false, err
+	}
+	return f, true, nil
+}
+
+// alignmentFor returns the alignment to use for f: the -align flag if set,
+// otherwise the filesystem's block size.
+func alignmentFor(f *os.File) int {
+	if *align > 0 {
+		return *align
+	}
+
+	var stat unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &stat); err != nil || stat.Bsize <= 0 {
+		return 4096
+	}
+	return int(stat.Bsize)
+}
+
+// directWriter copies every Write into a pooled, address- and length-
+// aligned buffer before handing it to an O_DIRECT file, then truncates back
+// to the real size on Close. It is single-use: it writes exactly once per
+// file (the shard is always written in one Write call), and a second Write
+// panics.
+type directWriter struct {
+	f       *os.File
+	align   int
+	key     shardBufKey
+	size    int64
+	written bool
+}
+
+func (dw *directWriter) Write(p []byte) (int, error) {
+	if dw.written {
+		panic("directWriter: Write called more than once")
+	}
+	dw.written = true
+
+	size := len(p)
+	if rem := size % dw.align; rem != 0 {
+		size += dw.align - rem
+	}
+	// Always go through the pooled aligned buffer: p may come from
+	// concurrent.Writer's own copy, which has no alignment guarantee even
+	// when len(p) is already a multiple of dw.align.
+	buf := directBufPool.get(dw.key, size, dw.align)
+	copy(buf, p)
+
+	if _, err := dw.f.Write(buf); err != nil {
+		return 0, err
+	}
+	dw.size = int64(len(p))
+	return len(p), nil
+}
+
+func (dw *directWriter) Close() error {
+	if err := dw.f.Truncate(dw.size); err != nil {
+		dw.f.Close()
+		return err
+	}
+	return dw.f.Close()
+}
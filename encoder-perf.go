@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -12,6 +13,8 @@ import (
 
 	"github.com/klauspost/reedsolomon"
 	blake2b "github.com/minio/blake2b-simd"
+
+	"github.com/fwessels/encoder-perf/concurrent"
 )
 
 var dataShards = flag.Int("data", 4, "Number of shards to split the data into, must be below 257.")
@@ -20,11 +23,20 @@ var outDir = flag.String("out", "", "Alternative output directory")
 var workers = flag.Int("w", 1, "Number of workers to run in parallel.")
 var runs = flag.Int("r", 1000, "Total number of runs.")
 var nodisk = flag.Bool("nodisk", false, "Disable writes to disk.")
+var stream = flag.Bool("stream", false, "Use a streaming encoder so the whole file is never held in memory (with -nodisk the data shards are kept in memory).")
+var blockSize = flag.Int("blocksize", 1<<20, "Block size in bytes used by the streaming encoder.")
+var heal = flag.Bool("heal", false, "Heal mode: reconstruct missing/corrupt shards of the object in <tstr> instead of encoding a file.")
+var verify = flag.Bool("verify", false, "With -heal, only report checksum mismatches without writing anything back.")
+var shardWorkers = flag.Int("sw", 0, "Number of shards to hash and write concurrently per file (0 = all shards at once).")
+var replicaDir = flag.String("replica", "", "Optional second directory tree to additionally write every shard to.")
+var direct = flag.Bool("direct", false, "Open shard files with O_DIRECT and use aligned buffers, bypassing the page cache.")
+var align = flag.Int("align", 4096, "Alignment in bytes for -direct buffers and writes; 0 auto-detects the filesystem's block size.")
 
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  simple-encoder [-flags] filename.ext\n\n")
+		fmt.Fprintf(os.Stderr, "  simple-encoder [-flags] filename.ext\n")
+		fmt.Fprintf(os.Stderr, "  simple-encoder [-flags] -heal [-verify] tstr\n\n")
 		fmt.Fprintf(os.Stderr, "Valid flags:\n")
 		flag.PrintDefaults()
 	}
@@ -47,12 +59,30 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *dataShards < 1 {
+		fmt.Fprintf(os.Stderr, "Error: Need at least one data shard\n")
+		os.Exit(1)
+	}
 	if *dataShards > 257 {
 		fmt.Fprintf(os.Stderr, "Error: Too many data shards\n")
 		os.Exit(1)
 	}
+	if *stream && (*direct || *shardWorkers != 0 || *replicaDir != "") {
+		fmt.Fprintf(os.Stderr, "Error: -direct, -sw and -replica are not supported with -stream\n")
+		os.Exit(1)
+	}
+
+	if *heal {
+		erasureHealFile(args[0])
+		return
+	}
+
 	fname := args[0]
 
+	fi, err := os.Stat(fname)
+	checkErr(err)
+	shardSize := (fi.Size() + int64(*dataShards) - 1) / int64(*dataShards)
+
 	fmt.Println("Number of worker routines: ", *workers)
 
 	filesPerRout := *runs / *workers
@@ -60,6 +90,7 @@ func main() {
 	start := time.Now()
 
 	var wg sync.WaitGroup
+	perWorker := make([][]time.Duration, *workers)
 
 	for g := 0; g < *workers; g++ {
 
@@ -68,9 +99,17 @@ func main() {
 		go func(goroutine int) {
 			defer wg.Done()
 
+			durs := make([]time.Duration, 0, filesPerRout)
 			for f := 0; f < filesPerRout; f++ {
-				erasureCodeFile /*FullParallel*/ (fname, fmt.Sprintf("output-%d-%d", goroutine, f))
+				fstart := time.Now()
+				if *stream {
+					erasureCodeFileStream(fname, fmt.Sprintf("output-%d-%d", goroutine, f))
+				} else {
+					erasureCodeFile /*FullParallel*/ (fname, fmt.Sprintf("output-%d-%d", goroutine, f), goroutine)
+				}
+				durs = append(durs, time.Since(fstart))
 			}
+			perWorker[goroutine] = durs
 		}(g)
 	}
 
@@ -82,27 +121,33 @@ func main() {
 	fmt.Println("Elapsed time :", elapsed)
 	seconds := float64(elapsed) / float64(time.Second)
 	fmt.Printf("Speed        : %4.0f objs/sec\n", float64(totalObjs)/seconds)
+	printRunSummary(perWorker, *dataShards, *parShards, shardSize, elapsed)
+	printTimingSummary()
 }
 
-func getHash(data []byte) string {
-
-	h := blake2b.New512()
-	h.Write(data[:])
-	return fmt.Sprintf("%x", h.Sum(nil))
-}
-
-const json1 = `{"version":"1.0.0","format":"xl","stat":{"size":525968,"modTime":"2017-04-25T01:09:39.173066169Z"},"erasure":{"algorithm":"klauspost/reedsolomon/vandermonde","data":18,"parity":6,"blockSize":10485760,"index":9,"distribution":[7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,1,2,3,4,5,6],"checksum":[{"name":"part.1","algorithm":"blake2b","hash":"`
-const json2 = `"}]},"minio":{"release":"DEVELOPMENT.GOGET"},"meta":{"md5Sum":"956ac5e7286265b5da68ff33c05f6b35"},"parts":[{"number":1,"name":"part.1","etag":"","size":525968}]}`
-
-func erasureCodeFile(inputfile, outputfile string) {
+func erasureCodeFile(inputfile, outputfile string, worker int) {
 
 	// Create encoding matrix.
 	enc, err := reedsolomon.New(*dataShards, *parShards)
 	checkErr(err)
 
-	b, err := ioutil.ReadFile(inputfile)
+	fi, err := os.Stat(inputfile)
 	checkErr(err)
 
+	// Read the file into this worker's reusable buffer, leaving spare
+	// capacity beyond the file length so enc.Split slices it in place
+	// instead of allocating a fresh shard array for every file.
+	perShard := (int(fi.Size()) + *dataShards - 1) / *dataShards
+	needTotal := (*dataShards + *parShards) * perShard
+	buf := readBufPool.get(worker, needTotal+1)
+
+	f, err := os.Open(inputfile)
+	checkErr(err)
+	n, err := io.ReadFull(f, buf[:fi.Size()])
+	checkErr(f.Close())
+	checkErr(err)
+	b := buf[:n]
+
 	// Split the file into equally sized shards.
 	shards, err := enc.Split(b)
 	checkErr(err)
@@ -112,43 +157,125 @@ func erasureCodeFile(inputfile, outputfile string) {
 	checkErr(err)
 
 	// Write out the resulting files.
-	dir, _ /*file*/ := filepath.Split(outputfile)
-	if *outDir != "" {
-		dir = *outDir
-	}
-
 	tstr := Reverse(strings.ToLower(fmt.Sprintf("%X", time.Now().UnixNano())))
+	if *runs == 1 {
+		fmt.Println("Object:", tstr)
+	}
 	dirfmt := "/mnt/%s/disk%d/" + tstr[:2] + "/" + tstr[2:]
 	disk := []string{"sde1", "sdf1", "sdg1", "sdh1", "sdi1", "sdj1", "sdk1", "sdl1"}
 
-	for i, shard := range shards {
-		//outfn := fmt.Sprintf("%s.%d", file, i)
+	dirs := make([]string, len(shards))
+	disks := make([]string, len(shards))
+	hashes := make([]string, len(shards))
 
-		dir = fmt.Sprintf(dirfmt, disk[(i)%len(disk)], i+1)
+	for i := range shards {
+		disks[i] = disk[i%len(disk)]
+		dirs[i] = fmt.Sprintf(dirfmt, disks[i], i+1)
 		if !*nodisk {
-			os.MkdirAll(dir, os.ModePerm)
+			os.MkdirAll(dirs[i], os.ModePerm)
 		}
+	}
 
-		hash := getHash(shard)
+	// Dispatch shards across a worker pool instead of running the
+	// hash+write for each one sequentially, so hashing and disk I/O for
+	// different shards overlap.
+	poolSize := *shardWorkers
+	if poolSize <= 0 {
+		poolSize = len(shards)
+	}
+	sem := make(chan struct{}, poolSize)
 
-		if !*nodisk {
-			err = ioutil.WriteFile(filepath.Join(dir, "part.1" /*outfn*/), shard, os.ModePerm)
-			checkErr(err)
-		}
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		sem <- struct{}{}
 
-		if !*nodisk {
-			err = ioutil.WriteFile(filepath.Join(dir, "xl.json"), []byte(json1+hash+json2), os.ModePerm)
+		go func(i int, shard []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			h := blake2b.New512()
+			writers := []io.Writer{h}
+
+			var part, replica io.WriteCloser
+			var err error
+			if !*nodisk {
+				f, isDirect, ferr := createShardFile(filepath.Join(dirs[i], "part.1"))
+				checkErr(ferr)
+				if isDirect {
+					part = &directWriter{f: f, align: alignmentFor(f), key: shardBufKey{worker: worker, shard: i}}
+				} else {
+					part = f
+				}
+				writers = append(writers, part)
+
+				if *replicaDir != "" {
+					replicaPath := filepath.Join(*replicaDir, dirs[i])
+					checkErr(os.MkdirAll(replicaPath, os.ModePerm))
+					rf, rIsDirect, rerr := createShardFile(filepath.Join(replicaPath, "part.1"))
+					checkErr(rerr)
+					if rIsDirect {
+						replica = &directWriter{f: rf, align: alignmentFor(rf), key: shardBufKey{worker: worker, shard: i, replica: true}}
+					} else {
+						replica = rf
+					}
+					writers = append(writers, replica)
+				}
+			}
+
+			// Tee the shard into the hasher and the disk writer(s)
+			// simultaneously instead of hashing and then writing in turn.
+			cw := concurrent.New(1, writers...)
+			_, err = cw.Write(shard)
 			checkErr(err)
-		}
+			checkErr(cw.Wait())
+
+			if part != nil {
+				checkErr(part.Close())
+			}
+			if replica != nil {
+				checkErr(replica.Close())
+			}
+
+			durs := cw.Durations()
+			var writeTime time.Duration
+			for _, d := range durs[1:] {
+				writeTime += d
+			}
+			addShardTiming(durs[0], writeTime)
+
+			hashes[i] = fmt.Sprintf("%x", h.Sum(nil))
+		}(i, shard)
 	}
+	wg.Wait()
 
-	/*      for i, shard := range shards {
-			outfn := fmt.Sprintf("%s.%d", file, i)
+	if !*nodisk {
+		writeMetadata(dirs, disks, inputfile, fi.Size(), fi.ModTime(), int64(len(shards[0])), hashes)
+	}
+}
 
-			err = ioutil.WriteFile(filepath.Join(dir, outfn), shard, os.ModePerm)
-			checkErr(err)
-		}
-	*/
+// fileReadBufPool hands out one reusable read buffer per worker, grown with
+// spare capacity so reedsolomon.Split can reuse it directly instead of
+// allocating a fresh shard array on every call.
+type fileReadBufPool struct {
+	mu      sync.Mutex
+	byOwner map[int][]byte
+}
+
+var readBufPool = &fileReadBufPool{byOwner: map[int][]byte{}}
+
+// get returns worker's read buffer, growing it if it is smaller than
+// minCap. The returned slice has len == minCap; its cap is at least minCap.
+func (p *fileReadBufPool) get(worker, minCap int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	buf := p.byOwner[worker]
+	if cap(buf) < minCap {
+		buf = make([]byte, minCap)
+		p.byOwner[worker] = buf
+	}
+	return buf[:minCap]
 }
 
 func erasureCodeFileFullParallel(inputfile, outputfile string) {
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/reedsolomon"
+	blake2b "github.com/minio/blake2b-simd"
+)
+
+// erasureHealFile verifies every shard of a previously encoded object
+// against its xl.json and, unless -verify is set, reconstructs and rewrites
+// any shard that is missing, corrupt, or out of place. Only the stale disks
+// are touched. tstr is the same time-derived string erasureCodeFile used to
+// lay out the per-disk directory tree for the object.
+func erasureHealFile(tstr string) {
+
+	dirfmt := "/mnt/%s/disk%d/" + tstr[:2] + "/" + tstr[2:]
+	disk := []string{"sde1", "sdf1", "sdg1", "sdh1", "sdi1", "sdj1", "sdk1", "sdl1"}
+
+	total := *dataShards + *parShards
+	dirs := make([]string, total)
+	disks := make([]string, total)
+	for i := range dirs {
+		disks[i] = disk[i%len(disk)]
+		dirs[i] = fmt.Sprintf(dirfmt, disks[i], i+1)
+	}
+
+	metas := make([]*Metadata, total)
+	for i, dir := range dirs {
+		m, err := readMetadata(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shard %d (%s): %s, marking stale\n", i, dir, err.Error())
+			continue
+		}
+		metas[i] = m
+	}
+
+	var ref *Metadata
+	for _, m := range metas {
+		if m != nil {
+			ref = m
+			break
+		}
+	}
+	if ref == nil {
+		fmt.Fprintln(os.Stderr, "no readable xl.json found for any shard, cannot heal")
+		return
+	}
+	if ref.Erasure.Data != *dataShards || ref.Erasure.Parity != *parShards {
+		fmt.Fprintf(os.Stderr, "recorded shard layout (data=%d, parity=%d) does not match -data/-par, aborting\n", ref.Erasure.Data, ref.Erasure.Parity)
+		os.Exit(2)
+	}
+
+	enc, err := reedsolomon.New(*dataShards, *parShards)
+	checkErr(err)
+
+	stale := make([]bool, total)
+	var shardSize int64
+
+	// First pass: verify every shard's position and checksum.
+	for i, dir := range dirs {
+		if metas[i] == nil || i >= len(ref.Erasure.Checksums) {
+			stale[i] = true
+			continue
+		}
+		if metas[i].Erasure.Index != i {
+			fmt.Fprintf(os.Stderr, "shard %d (%s): xl.json claims index %d, marking stale\n", i, dir, metas[i].Erasure.Index)
+			stale[i] = true
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(dir, "part.1"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shard %d (%s): %s, marking stale\n", i, dir, err.Error())
+			stale[i] = true
+			continue
+		}
+
+		fi, err := f.Stat()
+		checkErr(err)
+
+		h := blake2b.New512()
+		_, err = io.Copy(h, f)
+		checkErr(err)
+		f.Close()
+
+		want := ref.Erasure.Checksums[i].Hash
+		if gotHash := fmt.Sprintf("%x", h.Sum(nil)); gotHash != want {
+			fmt.Fprintf(os.Stderr, "shard %d (%s): checksum mismatch (possibly swapped or corrupt), marking stale\n", i, dir)
+			stale[i] = true
+			continue
+		}
+
+		if fi.Size() > shardSize {
+			shardSize = fi.Size()
+		}
+	}
+
+	if *verify {
+		return
+	}
+
+	verified := 0
+	for _, s := range stale {
+		if !s {
+			verified++
+		}
+	}
+	if verified == total {
+		fmt.Println("All shards verified OK, nothing to heal.")
+		return
+	}
+	if verified < *dataShards {
+		fmt.Fprintf(os.Stderr, "only %d of %d shards verified OK, need at least %d data shards to reconstruct, aborting\n", verified, total, *dataShards)
+		os.Exit(2)
+	}
+
+	readers := make([]*os.File, total)
+	writers := make([]*os.File, total)
+	hashers := make([]hash.Hash, total)
+	for i, dir := range dirs {
+		if stale[i] {
+			checkErr(os.MkdirAll(dir, os.ModePerm))
+			f, err := os.Create(filepath.Join(dir, "part.1"))
+			checkErr(err)
+			writers[i] = f
+			hashers[i] = blake2b.New512()
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, "part.1"))
+		checkErr(err)
+		readers[i] = f
+	}
+
+	blocks := make([][]byte, total)
+	for i := range blocks {
+		blocks[i] = make([]byte, *blockSize)
+	}
+
+	for off := int64(0); off < shardSize; off += int64(*blockSize) {
+		chunksize := int64(*blockSize)
+		if off+chunksize > shardSize {
+			chunksize = shardSize - off
+		}
+
+		shards := make([][]byte, total)
+		for i := range shards {
+			if stale[i] {
+				// Zero-length but sufficient capacity, so Reconstruct
+				// reuses blocks[i] instead of allocating.
+				shards[i] = blocks[i][:0]
+				continue
+			}
+			buf := blocks[i][:chunksize]
+			_, err := io.ReadFull(readers[i], buf)
+			checkErr(err)
+			shards[i] = buf
+		}
+
+		checkErr(enc.Reconstruct(shards))
+
+		for i, s := range stale {
+			if !s {
+				continue
+			}
+			hashers[i].Write(shards[i])
+			_, err := writers[i].Write(shards[i])
+			checkErr(err)
+		}
+	}
+
+	for _, f := range readers {
+		if f != nil {
+			checkErr(f.Close())
+		}
+	}
+
+	checksums := make([]Checksum, total)
+	for i := range checksums {
+		if stale[i] {
+			checksums[i] = Checksum{Index: i, Disk: disks[i], Hash: fmt.Sprintf("%x", hashers[i].Sum(nil))}
+		} else {
+			checksums[i] = ref.Erasure.Checksums[i]
+		}
+	}
+
+	for i, dir := range dirs {
+		if !stale[i] {
+			continue
+		}
+		checkErr(writers[i].Close())
+		writeMetadataTo(dir, disks, i, ref.Stat.Filename, ref.Stat.Size, ref.Stat.ModTime, ref.Erasure.ShardSize, checksums)
+		fmt.Println("healed shard", i, "->", filepath.Join(dir, "part.1"))
+	}
+}
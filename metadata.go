@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Metadata is the xl.json sidecar written into every shard's directory, one
+// copy per shard differing only in Erasure.Index.
+type Metadata struct {
+	Version string   `json:"version"`
+	Format  string   `json:"format"`
+	Stat    FileStat `json:"stat"`
+	Erasure Erasure  `json:"erasure"`
+}
+
+// FileStat records facts about the original, pre-encoding file.
+type FileStat struct {
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+}
+
+// Erasure describes how the file was split, which shard this particular
+// xl.json belongs to, and where every shard landed.
+type Erasure struct {
+	Algorithm    string     `json:"algorithm"`
+	Data         int        `json:"data"`
+	Parity       int        `json:"parity"`
+	ShardSize    int64      `json:"shardSize"`
+	Index        int        `json:"index"`
+	Distribution []string   `json:"distribution"`
+	Checksums    []Checksum `json:"checksums"`
+}
+
+// Checksum is the blake2b hash of one shard, keyed by its index.
+type Checksum struct {
+	Index int    `json:"index"`
+	Disk  string `json:"disk"`
+	Hash  string `json:"hash"`
+}
+
+// writeMetadata builds one Metadata value per shard - identical except for
+// Erasure.Index - and writes it as xl.json into each shard's directory.
+func writeMetadata(dirs, disks []string, srcName string, srcSize int64, modTime time.Time, shardSize int64, hashes []string) {
+
+	checksums := make([]Checksum, len(dirs))
+	for i := range dirs {
+		checksums[i] = Checksum{Index: i, Disk: disks[i], Hash: hashes[i]}
+	}
+
+	for i, dir := range dirs {
+		writeMetadataTo(dir, disks, i, srcName, srcSize, modTime, shardSize, checksums)
+	}
+}
+
+// writeMetadataTo writes the xl.json for a single shard at the given index.
+func writeMetadataTo(dir string, disks []string, index int, srcName string, srcSize int64, modTime time.Time, shardSize int64, checksums []Checksum) {
+	m := Metadata{
+		Version: "1.0.0",
+		Format:  "xl",
+		Stat: FileStat{
+			Filename: filepath.Base(srcName),
+			Size:     srcSize,
+			ModTime:  modTime,
+		},
+		Erasure: Erasure{
+			Algorithm:    "klauspost/reedsolomon/vandermonde",
+			Data:         *dataShards,
+			Parity:       *parShards,
+			ShardSize:    shardSize,
+			Index:        index,
+			Distribution: disks,
+			Checksums:    checksums,
+		},
+	}
+
+	b, err := json.Marshal(m)
+	checkErr(err)
+	checkErr(ioutil.WriteFile(filepath.Join(dir, "xl.json"), b, os.ModePerm))
+}
+
+// readMetadata reads and parses the xl.json in dir.
+func readMetadata(dir string) (*Metadata, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "xl.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("malformed xl.json: %s", err.Error())
+	}
+	return &m, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// stats accumulates timing information across all workers and files.
+var stats = struct {
+	mu        sync.Mutex
+	hashTime  time.Duration
+	writeTime time.Duration
+}{}
+
+// addShardTiming records the time spent hashing and writing a single shard,
+// as reported by a concurrent.Writer.
+func addShardTiming(hash, write time.Duration) {
+	stats.mu.Lock()
+	stats.hashTime += hash
+	stats.writeTime += write
+	stats.mu.Unlock()
+}
+
+// printTimingSummary prints the accumulated hashing vs. write time so users
+// can see which stage dominates.
+func printTimingSummary() {
+	stats.mu.Lock()
+	hashTime, writeTime := stats.hashTime, stats.writeTime
+	stats.mu.Unlock()
+
+	fmt.Printf("Hashing time : %s\n", hashTime)
+	fmt.Printf("Write time   : %s\n", writeTime)
+}
+
+// printRunSummary prints per-worker object counts, per-file latency
+// percentiles, and overall throughput/IOPS.
+func printRunSummary(perWorker [][]time.Duration, dataShards, parShards int, shardSize int64, elapsed time.Duration) {
+	var all []time.Duration
+	totalFiles := 0
+	for i, durs := range perWorker {
+		fmt.Printf("Worker %-3d   : %d objects\n", i, len(durs))
+		all = append(all, durs...)
+		totalFiles += len(durs)
+	}
+	if totalFiles == 0 {
+		return
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	fmt.Printf("Latency p50  : %s\n", percentile(all, 0.50))
+	fmt.Printf("Latency p95  : %s\n", percentile(all, 0.95))
+	fmt.Printf("Latency p99  : %s\n", percentile(all, 0.99))
+
+	seconds := elapsed.Seconds()
+	totalBytes := uint64(totalFiles) * uint64(dataShards) * uint64(shardSize)
+	fmt.Printf("Throughput   : %s/s\n", humanize.IBytes(uint64(float64(totalBytes)/seconds)))
+
+	totalShardWrites := float64(totalFiles * (dataShards + parShards))
+	fmt.Printf("IOPS         : %s\n", humanize.SI(totalShardWrites/seconds, "ops/s"))
+}
+
+// percentile returns the value at rank p (0..1) of an already sorted slice,
+// using nearest-rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
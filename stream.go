@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	blake2b "github.com/minio/blake2b-simd"
+)
+
+// erasureCodeFileStream is the streaming counterpart to erasureCodeFile,
+// reading and writing the file in *blockSize chunks instead of loading it
+// whole. With -nodisk the data shards are kept in memory instead of on disk,
+// so the whole file's data is then held in memory.
+func erasureCodeFileStream(inputfile, outputfile string) {
+
+	enc, err := reedsolomon.NewStream(*dataShards, *parShards, reedsolomon.WithStreamBlockSize(*blockSize))
+	checkErr(err)
+
+	in, err := os.Open(inputfile)
+	checkErr(err)
+	defer in.Close()
+
+	fi, err := in.Stat()
+	checkErr(err)
+
+	tstr := Reverse(strings.ToLower(fmt.Sprintf("%X", time.Now().UnixNano())))
+	if *runs == 1 {
+		fmt.Println("Object:", tstr)
+	}
+	dirfmt := "/mnt/%s/disk%d/" + tstr[:2] + "/" + tstr[2:]
+	disk := []string{"sde1", "sdf1", "sdg1", "sdh1", "sdi1", "sdj1", "sdk1", "sdl1"}
+
+	total := *dataShards + *parShards
+	dirs := make([]string, total)
+	disks := make([]string, total)
+	for i := range dirs {
+		disks[i] = disk[i%len(disk)]
+		dirs[i] = fmt.Sprintf(dirfmt, disks[i], i+1)
+		if !*nodisk {
+			checkErr(os.MkdirAll(dirs[i], os.ModePerm))
+		}
+	}
+
+	hashes := make([]hash.Hash, total)
+	for i := range hashes {
+		hashes[i] = blake2b.New512()
+	}
+
+	// Split the input into data shards.
+	dataOut := make([]io.Writer, *dataShards)
+	dataFiles := make([]*os.File, *dataShards)
+	dataBufs := make([]*bufio.Writer, *dataShards)
+	dataMem := make([]*bytes.Buffer, *dataShards)
+	for i := range dataOut {
+		if *nodisk {
+			dataMem[i] = new(bytes.Buffer)
+			dataOut[i] = io.MultiWriter(dataMem[i], hashes[i])
+			continue
+		}
+		f, err := os.Create(filepath.Join(dirs[i], "part.1"))
+		checkErr(err)
+		dataFiles[i] = f
+		dataBufs[i] = bufio.NewWriterSize(f, *blockSize)
+		dataOut[i] = io.MultiWriter(dataBufs[i], hashes[i])
+	}
+
+	err = enc.Split(in, dataOut, fi.Size())
+	checkErr(err)
+
+	for i, bw := range dataBufs {
+		if bw == nil {
+			continue
+		}
+		checkErr(bw.Flush())
+		checkErr(dataFiles[i].Close())
+	}
+
+	// Re-open the data shards for reading so Encode can stream the parity.
+	shards := make([]io.Reader, *dataShards)
+	for i := range shards {
+		if *nodisk {
+			shards[i] = bytes.NewReader(dataMem[i].Bytes())
+			continue
+		}
+		f, err := os.Open(filepath.Join(dirs[i], "part.1"))
+		checkErr(err)
+		defer f.Close()
+		shards[i] = f
+	}
+
+	parityOut := make([]io.Writer, *parShards)
+	parityFiles := make([]*os.File, *parShards)
+	parityBufs := make([]*bufio.Writer, *parShards)
+	for i := range parityOut {
+		idx := *dataShards + i
+		if *nodisk {
+			parityOut[i] = hashes[idx]
+			continue
+		}
+		f, err := os.Create(filepath.Join(dirs[idx], "part.1"))
+		checkErr(err)
+		parityFiles[i] = f
+		parityBufs[i] = bufio.NewWriterSize(f, *blockSize)
+		parityOut[i] = io.MultiWriter(parityBufs[i], hashes[idx])
+	}
+
+	err = enc.Encode(shards, parityOut)
+	checkErr(err)
+
+	for i, bw := range parityBufs {
+		if bw == nil {
+			continue
+		}
+		checkErr(bw.Flush())
+		checkErr(parityFiles[i].Close())
+	}
+
+	if *nodisk {
+		return
+	}
+
+	shardHashes := make([]string, total)
+	for i := range shardHashes {
+		shardHashes[i] = fmt.Sprintf("%x", hashes[i].Sum(nil))
+	}
+	shardSize := (fi.Size() + int64(*dataShards) - 1) / int64(*dataShards)
+	writeMetadata(dirs, disks, inputfile, fi.Size(), fi.ModTime(), shardSize, shardHashes)
+}